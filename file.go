@@ -2,38 +2,66 @@ package file
 
 import (
 	"bytes"
-	"encoding/json"
-	"io/ioutil"
+	"compress/gzip"
+	"container/list"
 	"log"
 	"os"
-	"sort"
 	"strconv"
-	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/gliderlabs/logspout/router"
+
+	"github.com/erbrecht/logspout-file-module/format"
+	_ "github.com/erbrecht/logspout-file-module/format/gelf"
+	_ "github.com/erbrecht/logspout-file-module/format/json"
+	_ "github.com/erbrecht/logspout-file-module/format/logfmt"
+	_ "github.com/erbrecht/logspout-file-module/format/raw"
+	_ "github.com/erbrecht/logspout-file-module/format/syslog5424"
 )
 
 //
 // file route exaple:
 //   file://sample.log?maxfilesize=102400
 //
+// rotated files can be gzip compressed in the background:
+//   file://sample.log?maxfilesize=102400&compress=true&compress_level=6
+//
+// in addition to size, files can be rotated hourly/daily or at a fixed
+// wall-clock time:
+//   file://sample.log?rotate_interval=24h
+//   file://sample.log?rotate_at=00:00
+//
+// the output format defaults to "json" but can be switched to any format
+// registered under format/ (raw, logfmt, syslog5424, gelf):
+//   file://sample.log?format=gelf
+//
+// writes go through a buffered writer (default 64KiB, flushed every 1s) to
+// avoid a syscall per log line; set sync=true to fsync after every flush:
+//   file://sample.log?write_buffer_bytes=262144&flush_interval=5s&sync=true
+//
+// route.Address (or filename_template) is evaluated as a Go text/template
+// per message, so one route can fan out to per-container/per-label files:
+//   file://{{.Container.Name}}.log?filename_template={{.Container.Name}}/{{.Container.Config.Labels.app}}.log
+//
+// besides maxfilecount, rotated files can also be pruned by age and by the
+// total size of the matching rotated files; all active limits compose:
+//   file://sample.log?maxage=168h&maxtotalsize=10GB
+//
+// maxfilecount defaults to 10 when unset; maxfilecount=0 disables
+// count-based pruning entirely.
+//
 
 func init() {
 	router.AdapterFactories.Register(NewFileAdapter, "file")
 }
 
-var funcs = template.FuncMap{
-	"toJSON": func(value interface{}) string {
-		bytes, err := json.Marshal(value)
-		if err != nil {
-			log.Println("error marshalling to JSON: ", err)
-			return "null"
-		}
-		return string(bytes)
-	},
-}
+const (
+	defaultWriteBufferBytes = 64 * 1024
+	defaultFlushInterval    = time.Second
+	defaultMaxOpenFiles     = 128
+)
 
 // NewFileAdapter returns a configured raw.Adapter
 func NewFileAdapter(route *router.Route) (router.LogAdapter, error) {
@@ -54,20 +82,28 @@ func NewFileAdapter(route *router.Route) (router.LogAdapter, error) {
 	// default log dir
 	logdir := "/var/log/"
 
-	// get 'filename' from route.Address
-	filename := "default.log"
+	// get 'filename' template from filename_template, falling back to
+	// route.Address. Either may be a plain name or a text/template.
+	filenameSrc := "default.log"
 	if route.Address != "" {
-		filename = route.Address
+		filenameSrc = route.Address
 	}
-	//log.Println("filename [",filename,"]")
+	if route.Options["filename_template"] != "" {
+		filenameSrc = route.Options["filename_template"]
+	}
+	//log.Println("filename [",filenameSrc,"]")
 
-	structuredData := route.Options["structured_data"]
+	filenameTmpl, err := template.New("filename").Parse(filenameSrc)
+	if err != nil {
+		return nil, err
+	}
 
-	tmplStr := "{ \"container\" : \"{{ .Container.Name }}\", \"labels\": {{ toJSON .Container.Config.Labels }}, \"timestamp\": \"{{ .Time.Format \"2006-01-02T15:04:05Z0700\" }}\", \"source\" : \"{{ .Source }}\", \"line\": {{.Data}}\n"
-	if structuredData != "true" {
-		tmplStr = "{ \"container\" : \"{{ .Container.Name }}\", \"labels\": {{ toJSON .Container.Config.Labels }}, \"timestamp\": \"{{ .Time.Format \"2006-01-02T15:04:05Z0700\" }}\", \"source\" : \"{{ .Source }}\", \"line\": {{ toJSON .Data }} }\n"
+	// default format json
+	formatName := route.Options["format"]
+	if formatName == "" {
+		formatName = "json"
 	}
-	tmpl, err := template.New("file").Funcs(funcs).Parse(tmplStr)
+	formatter, err := format.New(formatName, route)
 	if err != nil {
 		return nil, err
 	}
@@ -93,137 +129,352 @@ func NewFileAdapter(route *router.Route) (router.LogAdapter, error) {
 	}
 	//log.Println("maxfilesize [",maxfilesize,"]")
 
+	// no age-based retention by default
+	var maxage time.Duration
+	if route.Options["maxage"] != "" {
+		age, err := parseRetentionDuration(route.Options["maxage"])
+		if err == nil {
+			maxage = age
+		}
+	}
+
+	// no total-size-based retention by default
+	var maxtotalsize int64
+	if route.Options["maxtotalsize"] != "" {
+		sz, err := parseSize(route.Options["maxtotalsize"])
+		if err == nil {
+			maxtotalsize = sz
+		}
+	}
+
+	// default compress false
+	compress := route.Options["compress"] == "true"
+
+	// default compress_level gzip.DefaultCompression
+	compressLevel := gzip.DefaultCompression
+	if route.Options["compress_level"] != "" {
+		lvlStr := route.Options["compress_level"]
+		lvl, err := strconv.Atoi(lvlStr)
+		if err == nil && lvl >= 1 && lvl <= 9 {
+			compressLevel = lvl
+		}
+	}
+
+	// no time-based rotation by default
+	var rotateInterval time.Duration
+	if route.Options["rotate_interval"] != "" {
+		interval, err := time.ParseDuration(route.Options["rotate_interval"])
+		if err == nil {
+			rotateInterval = interval
+		}
+	}
+
+	// rotate_at is a daily wall-clock time, e.g. "00:00"
+	rotateAtSet := false
+	rotateAtHour, rotateAtMin := 0, 0
+	if route.Options["rotate_at"] != "" {
+		at, err := time.Parse("15:04", route.Options["rotate_at"])
+		if err == nil {
+			rotateAtSet = true
+			rotateAtHour = at.Hour()
+			rotateAtMin = at.Minute()
+		}
+	}
+
+	// default write_buffer_bytes 64KiB
+	writeBufferBytes := defaultWriteBufferBytes
+	if route.Options["write_buffer_bytes"] != "" {
+		sz, err := strconv.Atoi(route.Options["write_buffer_bytes"])
+		if err == nil {
+			writeBufferBytes = sz
+		}
+	}
+
+	// default flush_interval 1s
+	flushInterval := defaultFlushInterval
+	if route.Options["flush_interval"] != "" {
+		interval, err := time.ParseDuration(route.Options["flush_interval"])
+		if err == nil {
+			flushInterval = interval
+		}
+	}
+
+	// default sync false
+	syncOnFlush := route.Options["sync"] == "true"
+
+	// default max_open_files 128
+	maxOpenFiles := defaultMaxOpenFiles
+	if route.Options["max_open_files"] != "" {
+		n, err := strconv.Atoi(route.Options["max_open_files"])
+		if err == nil && n > 0 {
+			maxOpenFiles = n
+		}
+	}
+
 	a := Adapter{
-		route:        route,
-		filename:     filename,
-		logdir:       logdir,
-		maxfilesize:  maxfilesize,
-		maxfilecount: maxfilecount,
-		tmpl:         tmpl,
-		checklogfile: checkLogFileExists,
+		route:            route,
+		logdir:           logdir,
+		filenameTmpl:     filenameTmpl,
+		maxfilesize:      maxfilesize,
+		maxfilecount:     maxfilecount,
+		maxage:           maxage,
+		maxtotalsize:     maxtotalsize,
+		formatter:        formatter,
+		checklogfile:     checkLogFileExists,
+		compress:         compress,
+		compressLevel:    compressLevel,
+		rotateInterval:   rotateInterval,
+		rotateAtSet:      rotateAtSet,
+		rotateAtHour:     rotateAtHour,
+		rotateAtMin:      rotateAtMin,
+		now:              time.Now,
+		writeBufferBytes: writeBufferBytes,
+		flushInterval:    flushInterval,
+		syncOnFlush:      syncOnFlush,
+		maxOpenFiles:     maxOpenFiles,
+		files:            map[string]*fileHandle{},
+		lru:              list.New(),
+		lruElems:         map[string]*list.Element{},
+		seen:             map[string]bool{},
 	}
 
-	// rename if exists, otherwise create it
-	err = a.Rotate()
-	if err != nil {
-		return nil, err
+	// if the filename doesn't depend on per-message fields (the common,
+	// single-file case) we can rename/create it eagerly, just like before.
+	// templated filenames are resolved lazily, per message, in Stream.
+	if path, err := a.resolvePath(nil); err == nil {
+		if _, err := a.getOrCreateHandle(path); err != nil {
+			return nil, err
+		}
 	}
+
+	go a.runFlusher()
+
 	return &a, nil
 }
 
-// Adapter is a simple adapter that streams log output to a connection without any templating
+// Adapter streams log output to one or more files under logdir, selected
+// per message by evaluating filenameTmpl.
 type Adapter struct {
-	filename     string
+	route        *router.Route
 	logdir       string
-	filesize     int
+	filenameTmpl *template.Template
+	formatter    format.Formatter
+	checklogfile bool
+
 	maxfilesize  int
 	maxfilecount int
-	fp           *os.File
-	route        *router.Route
-	tmpl         *template.Template
-	checklogfile bool
+	maxage       time.Duration
+	maxtotalsize int64
+
+	compress      bool
+	compressLevel int
+
+	// time-based rotation
+	rotateInterval time.Duration
+	rotateAtSet    bool
+	rotateAtHour   int
+	rotateAtMin    int
+	now            func() time.Time
+
+	// buffered writes
+	writeBufferBytes int
+	flushInterval    time.Duration
+	syncOnFlush      bool
+
+	// open file handles, keyed by the path resolved from filenameTmpl,
+	// bounded by maxOpenFiles with least-recently-used eviction.
+	maxOpenFiles int
+	mu           sync.Mutex
+	files        map[string]*fileHandle
+	lru          *list.List
+	lruElems     map[string]*list.Element
+
+	// seen records every path this adapter has ever opened, so a path
+	// evicted from the LRU and then reused can be told apart from one this
+	// adapter has never touched before. See getOrCreateHandle.
+	seen map[string]bool
 }
 
-// CheckFile makes sure file exists for writing
-func (a *Adapter) CheckFile() (err error) {
-	if _, err := os.Stat(a.logdir + a.filename); os.IsNotExist(err) {
-		// file doesn't exist. create it
-		a.fp, err = os.Create(a.logdir + a.filename)
-		// set size to 0
-		if err != nil {
-			return err
+// resolvePath evaluates filenameTmpl against message, returning the path
+// (relative to logdir) of the file that message should be written to.
+func (a *Adapter) resolvePath(message *router.Message) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := a.filenameTmpl.Execute(buf, message); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// getOrCreateHandle returns the open fileHandle for path, opening it (and
+// evicting the least-recently-used handle if at maxOpenFiles) if needed.
+func (a *Adapter) getOrCreateHandle(path string) (*fileHandle, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if h, ok := a.files[path]; ok {
+		a.lru.MoveToFront(a.lruElems[path])
+		return h, nil
+	}
+
+	if len(a.files) >= a.maxOpenFiles {
+		a.evictOldestLocked()
+	}
+
+	h := &fileHandle{adapter: a, path: path}
+
+	// A path we've never opened before goes through rotate(), which renames
+	// any stale file already on disk aside (e.g. left over from a previous
+	// process) before creating a fresh one. A path we've opened before but
+	// evicted from the LRU is reopened in place instead: it's still "this
+	// file", just temporarily closed, so appending to it rather than
+	// rotating it avoids spurious rotation on every cache miss.
+	var err error
+	if a.seen[path] {
+		err = h.reopen()
+	} else {
+		err = h.rotate()
+		if a.seen == nil {
+			a.seen = map[string]bool{}
 		}
-		a.filesize = 0
+		a.seen[path] = true
 	}
-	return nil
+	if err != nil {
+		return nil, err
+	}
+	a.files[path] = h
+	a.lruElems[path] = a.lru.PushFront(path)
+	return h, nil
+}
+
+// evictOldestLocked closes and forgets the least-recently-used open file
+// handle. Callers must hold a.mu.
+func (a *Adapter) evictOldestLocked() {
+	elem := a.lru.Back()
+	if elem == nil {
+		return
+	}
+	path := elem.Value.(string)
+	if h, ok := a.files[path]; ok {
+		h.close()
+	}
+	delete(a.files, path)
+	delete(a.lruElems, path)
+	a.lru.Remove(elem)
 }
 
 // Stream sends log data to a connection
 func (a *Adapter) Stream(logstream chan *router.Message) {
 	for message := range logstream {
-		buf := new(bytes.Buffer)
-		err := a.tmpl.Execute(buf, message)
+		path, err := a.resolvePath(message)
 		if err != nil {
 			log.Println("err:", err)
-			return
+			continue
+		}
+
+		handle, err := a.getOrCreateHandle(path)
+		if err != nil {
+			log.Println("err:", err)
+			continue
 		}
 
 		if a.checklogfile {
-			a.CheckFile()
+			if err := handle.checkFile(); err != nil {
+				log.Println("err:", err)
+			}
 		}
 
-		//log.Println("debug:", buf.String())
-		_, err = a.fp.Write(buf.Bytes())
+		data, err := a.formatter.Format(message)
 		if err != nil {
 			log.Println("err:", err)
+			continue
 		}
 
-		// update file size
-		a.filesize = a.filesize + len(buf.Bytes())
-
-		// rotate file if size exceed max size
-		if a.filesize > a.maxfilesize {
-			a.Rotate()
+		//log.Println("debug:", string(data))
+		if err := handle.write(data); err != nil {
+			log.Println("err:", err)
 		}
 	}
 }
 
-// PruneLogs removes old log files
-func (a *Adapter) PruneLogs() (err error) {
-	// get listing of directory entries
-	entries, err := ioutil.ReadDir(a.logdir)
-	if err != nil {
-		return err
+// PruneLogs removes old log files for every currently open file handle
+func (a *Adapter) PruneLogs() error {
+	a.mu.Lock()
+	handles := make([]*fileHandle, 0, len(a.files))
+	for _, h := range a.files {
+		handles = append(handles, h)
 	}
+	a.mu.Unlock()
 
-	// limit to regular files that contain the appropriate file name
-	files := []os.FileInfo{}
-	for _, entry := range entries {
-		if entry.Mode().IsRegular() && strings.Contains(entry.Name(), a.filename) {
-			files = append(files, entry)
+	for _, h := range handles {
+		if err := h.pruneLogs(); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// sort files by modified date
-	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+// Rotate rotates the adapter's single default log file. It exists for
+// backward compatibility with routes that don't use filename templating.
+func (a *Adapter) Rotate() error {
+	path, err := a.resolvePath(nil)
+	if err != nil {
+		return err
+	}
+	handle, err := a.getOrCreateHandle(path)
+	if err != nil {
+		return err
+	}
+	return handle.rotate()
+}
 
-	// grab all but last <maxfilecount> files
-	toPrune := files[0 : len(files)-a.maxfilecount]
+// runFlusher periodically flushes every open file handle's buffered writes
+// to disk so data isn't held in memory for longer than flush_interval,
+// independent of how often Stream() is rotating or receiving messages. It
+// stops once the route is closed, so removing a route at runtime (logspout
+// supports this via its HTTP routes API) doesn't leak the goroutine.
+func (a *Adapter) runFlusher() {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
 
-	// remove files
-	for _, fi := range toPrune {
-		os.Remove(a.logdir + fi.Name())
+	var closed <-chan bool
+	if a.route != nil {
+		closed = a.route.Closer()
 	}
 
-	return nil
-}
+	for {
+		select {
+		case <-ticker.C:
+			a.mu.Lock()
+			handles := make([]*fileHandle, 0, len(a.files))
+			for _, h := range a.files {
+				handles = append(handles, h)
+			}
+			a.mu.Unlock()
 
-// Rotate rotates log file
-func (a *Adapter) Rotate() (err error) {
-	// Close existing file if open
-	if a.fp != nil {
-		err = a.fp.Close()
-		//log.Println("Close existing file pointer")
-		a.fp = nil
-		if err != nil {
-			return err
+			for _, h := range handles {
+				h.flush()
+			}
+		case <-closed:
+			return
 		}
 	}
-	// Rename dest file if it already exists
-	_, err = os.Stat(a.logdir + a.filename)
-	if err == nil {
-		err = os.Rename(a.logdir+a.filename, a.logdir+a.filename+"."+time.Now().Format(time.RFC3339))
-		log.Println("Rename existing log file")
-		if err != nil {
-			return err
-		}
+}
+
+// clock returns the adapter's injected now func, defaulting to time.Now so
+// zero-value Adapters (e.g. in tests) work without wiring one up explicitly.
+func (a *Adapter) clock() time.Time {
+	if a.now != nil {
+		return a.now()
 	}
-	// Create new file.
-	a.fp, err = os.Create(a.logdir + a.filename)
-	log.Println("Create new log file")
-	if err != nil {
-		return err
+	return time.Now()
+}
+
+// nextDailyRotate returns the next occurrence of rotateAtHour:rotateAtMin
+// strictly after from.
+func (a *Adapter) nextDailyRotate(from time.Time) time.Time {
+	next := time.Date(from.Year(), from.Month(), from.Day(), a.rotateAtHour, a.rotateAtMin, 0, 0, from.Location())
+	if !next.After(from) {
+		next = next.AddDate(0, 0, 1)
 	}
-	a.filesize = 0
-	return nil
+	return next
 }
@@ -0,0 +1,303 @@
+package file
+
+import (
+	"bufio"
+	"container/list"
+	"os"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// newTestAdapter builds an Adapter with a fixed, injectable clock and
+// a single open fileHandle for "test.log", ready for rotation tests.
+func newTestAdapter(t *testing.T) (*Adapter, *fileHandle, *time.Time) {
+	t.Helper()
+	dir := t.TempDir() + "/"
+
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+
+	tmpl, err := template.New("filename").Parse("test.log")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	a := &Adapter{
+		logdir:       dir,
+		filenameTmpl: tmpl,
+		maxfilesize:  1024 * 1024,
+		maxfilecount: 10,
+		maxOpenFiles: defaultMaxOpenFiles,
+		now:          now,
+		files:        map[string]*fileHandle{},
+		lru:          list.New(),
+		lruElems:     map[string]*list.Element{},
+	}
+	h, err := a.getOrCreateHandle("test.log")
+	if err != nil {
+		t.Fatalf("getOrCreateHandle: %v", err)
+	}
+	return a, h, &clock
+}
+
+func TestRotateDueByInterval(t *testing.T) {
+	_, h, clock := newTestAdapter(t)
+	h.adapter.rotateInterval = time.Hour
+
+	h.mu.Lock()
+	due := h.rotateDueLocked()
+	h.mu.Unlock()
+	if due {
+		t.Fatal("expected no rotation immediately after creation")
+	}
+
+	*clock = clock.Add(59 * time.Minute)
+	h.mu.Lock()
+	due = h.rotateDueLocked()
+	h.mu.Unlock()
+	if due {
+		t.Fatal("expected no rotation before the interval elapses")
+	}
+
+	*clock = clock.Add(2 * time.Minute)
+	h.mu.Lock()
+	due = h.rotateDueLocked()
+	h.mu.Unlock()
+	if !due {
+		t.Fatal("expected rotation once the interval elapses")
+	}
+}
+
+func TestRotateDueByWallClock(t *testing.T) {
+	_, h, clock := newTestAdapter(t)
+	a := h.adapter
+	a.rotateAtSet = true
+	a.rotateAtHour = 0
+	a.rotateAtMin = 0
+	h.lastRotate = *clock
+	h.nextRotateAt = a.nextDailyRotate(*clock)
+
+	*clock = clock.Add(23 * time.Hour)
+	h.mu.Lock()
+	due := h.rotateDueLocked()
+	h.mu.Unlock()
+	if due {
+		t.Fatal("expected no rotation before the configured wall-clock time")
+	}
+
+	*clock = clock.Add(2 * time.Hour)
+	h.mu.Lock()
+	due = h.rotateDueLocked()
+	h.mu.Unlock()
+	if !due {
+		t.Fatal("expected rotation once the configured wall-clock time passes")
+	}
+}
+
+func TestRotateResetsTimeBookkeeping(t *testing.T) {
+	_, h, clock := newTestAdapter(t)
+	h.adapter.rotateInterval = time.Hour
+
+	*clock = clock.Add(2 * time.Hour)
+	if err := h.rotate(); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	h.mu.Lock()
+	due := h.rotateDueLocked()
+	h.mu.Unlock()
+	if due {
+		t.Fatal("expected rotateDue to be false immediately after rotate")
+	}
+}
+
+func TestGetOrCreateHandleEvictsLRU(t *testing.T) {
+	dir := t.TempDir() + "/"
+	tmpl, err := template.New("filename").Parse("unused.log")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	a := &Adapter{
+		logdir:       dir,
+		filenameTmpl: tmpl,
+		maxfilesize:  1024 * 1024,
+		maxfilecount: 10,
+		maxOpenFiles: 2,
+		now:          time.Now,
+		files:        map[string]*fileHandle{},
+		lru:          list.New(),
+		lruElems:     map[string]*list.Element{},
+	}
+
+	a1, err := a.getOrCreateHandle("a.log")
+	if err != nil {
+		t.Fatalf("getOrCreateHandle(a): %v", err)
+	}
+	if _, err := a.getOrCreateHandle("b.log"); err != nil {
+		t.Fatalf("getOrCreateHandle(b): %v", err)
+	}
+	if _, err := a.getOrCreateHandle("c.log"); err != nil {
+		t.Fatalf("getOrCreateHandle(c): %v", err)
+	}
+
+	if len(a.files) != 2 {
+		t.Fatalf("expected 2 open files after eviction, got %d", len(a.files))
+	}
+	if _, ok := a.files["a.log"]; ok {
+		t.Fatal("expected least-recently-used handle a.log to be evicted")
+	}
+	if a1.fp != nil {
+		t.Fatal("expected evicted handle's file to be closed")
+	}
+}
+
+// TestGetOrCreateHandleReopensWithoutRotating guards against the bug where
+// reusing a path after it was evicted from the LRU caused a spurious
+// rotation (rename + recreate) even though no size/time threshold had been
+// crossed: reopening an already-seen path should just append to it.
+func TestGetOrCreateHandleReopensWithoutRotating(t *testing.T) {
+	dir := t.TempDir() + "/"
+	tmpl, err := template.New("filename").Parse("unused.log")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	a := &Adapter{
+		logdir:       dir,
+		filenameTmpl: tmpl,
+		maxfilesize:  1024 * 1024,
+		maxfilecount: 10,
+		maxOpenFiles: 2,
+		now:          time.Now,
+		files:        map[string]*fileHandle{},
+		lru:          list.New(),
+		lruElems:     map[string]*list.Element{},
+	}
+
+	ha, err := a.getOrCreateHandle("a.log")
+	if err != nil {
+		t.Fatalf("getOrCreateHandle(a): %v", err)
+	}
+	if err := ha.write([]byte("first\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	ha.flush()
+
+	// evict a.log by opening two more distinct paths
+	if _, err := a.getOrCreateHandle("b.log"); err != nil {
+		t.Fatalf("getOrCreateHandle(b): %v", err)
+	}
+	if _, err := a.getOrCreateHandle("c.log"); err != nil {
+		t.Fatalf("getOrCreateHandle(c): %v", err)
+	}
+	if _, ok := a.files["a.log"]; ok {
+		t.Fatal("expected a.log to have been evicted")
+	}
+
+	ha2, err := a.getOrCreateHandle("a.log")
+	if err != nil {
+		t.Fatalf("getOrCreateHandle(a) reopen: %v", err)
+	}
+	if err := ha2.write([]byte("second\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	ha2.flush()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	names := map[string]bool{}
+	for _, e := range entries {
+		names[e.Name()] = true
+	}
+	for name := range names {
+		if name != "a.log" && name != "b.log" && name != "c.log" {
+			t.Fatalf("unexpected file %q: reopening a.log should not have rotated it", name)
+		}
+	}
+
+	data, err := os.ReadFile(dir + "a.log")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(data) != "first\nsecond\n" {
+		t.Fatalf("a.log content = %q, want both writes appended", string(data))
+	}
+}
+
+// TestRunFlusherStopsOnRouteClose guards against the goroutine/ticker leak
+// where runFlusher ran for the life of the process with nothing tying it to
+// the route: removing a route at runtime (logspout supports this via its
+// HTTP routes API) never stopped the old adapter's flusher.
+func TestRunFlusherStopsOnRouteClose(t *testing.T) {
+	route := &router.Route{}
+	closer := make(chan bool)
+	route.OverrideCloser(closer)
+	a := &Adapter{
+		route:         route,
+		flushInterval: time.Millisecond,
+		files:         map[string]*fileHandle{},
+		lru:           list.New(),
+		lruElems:      map[string]*list.Element{},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		a.runFlusher()
+		close(done)
+	}()
+
+	closer <- true
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("runFlusher did not stop after the route closed")
+	}
+}
+
+// syntheticMessage is a realistic log-line payload for the write benchmarks.
+var syntheticMessage = []byte(`{ "container" : "web-1", "labels": {"app":"web"}, "timestamp": "2020-01-01T00:00:00Z", "source" : "stdout", "line": "GET /healthz 200 1234us" }` + "\n")
+
+// BenchmarkWriteUnbuffered writes directly to the underlying *os.File, one
+// syscall per message, mirroring the adapter's pre-buffering behavior.
+func BenchmarkWriteUnbuffered(b *testing.B) {
+	fp, err := os.Create(b.TempDir() + "/unbuffered.log")
+	if err != nil {
+		b.Fatalf("Create: %v", err)
+	}
+	defer fp.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fp.Write(syntheticMessage); err != nil {
+			b.Fatalf("Write: %v", err)
+		}
+	}
+}
+
+// BenchmarkWriteBuffered writes through a bufio.Writer sized like the
+// adapter's default write_buffer_bytes, flushing once at the end.
+func BenchmarkWriteBuffered(b *testing.B) {
+	fp, err := os.Create(b.TempDir() + "/buffered.log")
+	if err != nil {
+		b.Fatalf("Create: %v", err)
+	}
+	defer fp.Close()
+	w := bufio.NewWriterSize(fp, defaultWriteBufferBytes)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.Write(syntheticMessage); err != nil {
+			b.Fatalf("Write: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		b.Fatalf("Flush: %v", err)
+	}
+}
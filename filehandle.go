@@ -0,0 +1,330 @@
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileHandle is a single open log file managed by an Adapter: its current
+// *os.File, its buffered writer, and the size/time-rotation bookkeeping for
+// just that file. Adapter keeps one fileHandle per resolved path so a
+// single route can fan out to per-container/per-label files.
+type fileHandle struct {
+	adapter *Adapter
+	path    string // relative to adapter.logdir
+
+	mu           sync.Mutex
+	fp           *os.File
+	bufWriter    *bufio.Writer
+	filesize     int
+	lastRotate   time.Time
+	nextRotateAt time.Time
+}
+
+// fullPath returns the file's absolute path on disk.
+func (h *fileHandle) fullPath() string {
+	return filepath.Join(h.adapter.logdir, h.path)
+}
+
+// write appends data to the file, then rotates it if the size or a
+// configured time threshold has been exceeded.
+func (h *fileHandle) write(data []byte) error {
+	h.mu.Lock()
+	_, err := h.bufWriter.Write(data)
+	if err == nil {
+		h.filesize += len(data)
+	}
+	needRotate := h.filesize > h.adapter.maxfilesize || h.rotateDueLocked()
+	h.mu.Unlock()
+
+	if needRotate {
+		if rerr := h.rotate(); rerr != nil {
+			return rerr
+		}
+	}
+	return err
+}
+
+// rotateDueLocked reports whether a time-based rotation (rotate_interval or
+// rotate_at) is due. Callers must hold h.mu.
+func (h *fileHandle) rotateDueLocked() bool {
+	a := h.adapter
+	if a.rotateInterval <= 0 && !a.rotateAtSet {
+		return false
+	}
+	now := a.clock()
+	if a.rotateInterval > 0 && now.Sub(h.lastRotate) >= a.rotateInterval {
+		return true
+	}
+	if a.rotateAtSet && !now.Before(h.nextRotateAt) {
+		return true
+	}
+	return false
+}
+
+// checkFile makes sure the file exists, recreating it if it was removed
+// out from under the adapter while logspout was running.
+func (h *fileHandle) checkFile() error {
+	if _, err := os.Stat(h.fullPath()); os.IsNotExist(err) {
+		return h.rotate()
+	}
+	return nil
+}
+
+// rotate closes the current file (if any), renames it aside if it already
+// exists on disk, and opens a fresh file in its place. It is also how a
+// fileHandle is first opened, since a nil fp is simply skipped.
+func (h *fileHandle) rotate() (err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.fp != nil {
+		if h.bufWriter != nil {
+			if err = h.bufWriter.Flush(); err != nil {
+				return err
+			}
+			h.bufWriter = nil
+		}
+		//log.Println("Close existing file pointer")
+		if err = h.fp.Close(); err != nil {
+			return err
+		}
+		h.fp = nil
+	}
+
+	full := h.fullPath()
+	if err = os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+
+	// Rename dest file if it already exists
+	if _, err = os.Stat(full); err == nil {
+		rotated := full + "." + h.adapter.clock().Format(time.RFC3339)
+		if err = os.Rename(full, rotated); err != nil {
+			return err
+		}
+		log.Println("Rename existing log file")
+		if h.adapter.compress {
+			go h.adapter.compressFile(rotated)
+		}
+	}
+
+	// Create new file.
+	h.fp, err = os.Create(full)
+	if err != nil {
+		return err
+	}
+	log.Println("Create new log file")
+	h.bufWriter = bufio.NewWriterSize(h.fp, h.adapter.writeBufferBytes)
+	h.filesize = 0
+
+	h.lastRotate = h.adapter.clock()
+	if h.adapter.rotateAtSet {
+		h.nextRotateAt = h.adapter.nextDailyRotate(h.lastRotate)
+	}
+
+	if err := h.pruneLogs(); err != nil {
+		log.Println("err:", err)
+	}
+	return nil
+}
+
+// reopen opens the file at h's path in append mode, seeding filesize from
+// whatever is already on disk, instead of rotating it aside. It's used when
+// a path is reused after its handle was evicted from the adapter's LRU: the
+// file is still "live" as far as this adapter is concerned, so picking up
+// where it left off is correct and a fresh rotate() is not.
+func (h *fileHandle) reopen() (err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	full := h.fullPath()
+	if err = os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+
+	h.fp, err = os.OpenFile(full, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := h.fp.Stat()
+	if err != nil {
+		h.fp.Close()
+		return err
+	}
+
+	h.bufWriter = bufio.NewWriterSize(h.fp, h.adapter.writeBufferBytes)
+	h.filesize = int(info.Size())
+
+	h.lastRotate = h.adapter.clock()
+	if h.adapter.rotateAtSet {
+		h.nextRotateAt = h.adapter.nextDailyRotate(h.lastRotate)
+	}
+	return nil
+}
+
+// flush pushes any buffered data to disk, syncing too if sync=true.
+func (h *fileHandle) flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.bufWriter == nil {
+		return
+	}
+	if err := h.bufWriter.Flush(); err != nil {
+		log.Println("err:", err)
+		return
+	}
+	if h.adapter.syncOnFlush && h.fp != nil {
+		if err := h.fp.Sync(); err != nil {
+			log.Println("err:", err)
+		}
+	}
+}
+
+// close flushes and closes the file, e.g. when evicted from the adapter's
+// open-file LRU.
+func (h *fileHandle) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.bufWriter != nil {
+		if err := h.bufWriter.Flush(); err != nil {
+			log.Println("err:", err)
+		}
+		h.bufWriter = nil
+	}
+	if h.fp != nil {
+		if err := h.fp.Close(); err != nil {
+			log.Println("err:", err)
+		}
+		h.fp = nil
+	}
+}
+
+// pruneLogs removes old rotated copies of this file according to whichever
+// of maxfilecount, maxage and maxtotalsize are configured. A file is
+// removed if it fails any active policy.
+func (h *fileHandle) pruneLogs() error {
+	dir := filepath.Dir(h.fullPath())
+	base := filepath.Base(h.path)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// limit to regular files that contain the appropriate file name, leaving
+	// out the active file itself so only rotated copies are counted and pruned
+	files := []os.FileInfo{}
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && strings.Contains(entry.Name(), base) && entry.Name() != base {
+			files = append(files, entry)
+		}
+	}
+
+	// sort files by modified date, oldest first
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+
+	toPrune := map[string]os.FileInfo{}
+
+	// grab all but the last <maxfilecount> files
+	if h.adapter.maxfilecount > 0 && len(files) > h.adapter.maxfilecount {
+		for _, fi := range files[:len(files)-h.adapter.maxfilecount] {
+			toPrune[fi.Name()] = fi
+		}
+	}
+
+	// anything older than maxage
+	if h.adapter.maxage > 0 {
+		cutoff := h.adapter.clock().Add(-h.adapter.maxage)
+		for _, fi := range files {
+			if fi.ModTime().Before(cutoff) {
+				toPrune[fi.Name()] = fi
+			}
+		}
+	}
+
+	// oldest-first until the total size of what's left fits maxtotalsize
+	if h.adapter.maxtotalsize > 0 {
+		var total int64
+		for _, fi := range files {
+			total += fi.Size()
+		}
+		for _, fi := range files {
+			if total <= h.adapter.maxtotalsize {
+				break
+			}
+			toPrune[fi.Name()] = fi
+			total -= fi.Size()
+		}
+	}
+
+	// remove files
+	for _, fi := range toPrune {
+		os.Remove(filepath.Join(dir, fi.Name()))
+	}
+
+	return nil
+}
+
+// compressFile gzips the rotated file at path and removes the uncompressed
+// original once the .gz copy has been fsync'd. It is intended to run in its
+// own goroutine so Stream() is never blocked waiting on compression.
+func (a *Adapter) compressFile(path string) {
+	src, err := os.Open(path)
+	if err != nil {
+		log.Println("err:", err)
+		return
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		log.Println("err:", err)
+		return
+	}
+
+	gw, err := gzip.NewWriterLevel(dst, a.compressLevel)
+	if err != nil {
+		log.Println("err:", err)
+		dst.Close()
+		return
+	}
+
+	_, err = io.Copy(gw, src)
+	if err != nil {
+		log.Println("err:", err)
+		gw.Close()
+		dst.Close()
+		return
+	}
+	if err = gw.Close(); err != nil {
+		log.Println("err:", err)
+		dst.Close()
+		return
+	}
+	if err = dst.Sync(); err != nil {
+		log.Println("err:", err)
+		dst.Close()
+		return
+	}
+	if err = dst.Close(); err != nil {
+		log.Println("err:", err)
+		return
+	}
+
+	if err = os.Remove(path); err != nil {
+		log.Println("err:", err)
+	}
+}
@@ -0,0 +1,108 @@
+package file
+
+import (
+	"compress/gzip"
+	"container/list"
+	"io/ioutil"
+	"os"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// TestCompressFile verifies compressFile gzips the rotated file in place and
+// removes the uncompressed original.
+func TestCompressFile(t *testing.T) {
+	dir := t.TempDir() + "/"
+	a := &Adapter{compressLevel: gzip.DefaultCompression}
+
+	path := dir + "test.log.2020-01-01T00:00:00Z"
+	want := "line one\nline two\n"
+	if err := os.WriteFile(path, []byte(want), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	a.compressFile(path)
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected uncompressed original to be removed")
+	}
+
+	fp, err := os.Open(path + ".gz")
+	if err != nil {
+		t.Fatalf("expected .gz file to exist: %v", err)
+	}
+	defer fp.Close()
+
+	gr, err := gzip.NewReader(fp)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	defer gr.Close()
+
+	got, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("decompressed content = %q, want %q", got, want)
+	}
+}
+
+// TestPruneLogsRecognizesGzFiles confirms that rotated files which have
+// already been gzip-compressed (a .gz suffix) are still matched by name and
+// pruned by maxage, not left behind forever.
+func TestPruneLogsRecognizesGzFiles(t *testing.T) {
+	dir := t.TempDir() + "/"
+	tmpl, err := template.New("filename").Parse("test.log")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	clock := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	a := &Adapter{
+		logdir:       dir,
+		filenameTmpl: tmpl,
+		maxfilesize:  1024 * 1024,
+		maxfilecount: 100, // high enough that count alone wouldn't prune
+		maxage:       24 * time.Hour,
+		now:          func() time.Time { return clock },
+		files:        map[string]*fileHandle{},
+		lru:          list.New(),
+		lruElems:     map[string]*list.Element{},
+	}
+
+	h, err := a.getOrCreateHandle("test.log")
+	if err != nil {
+		t.Fatalf("getOrCreateHandle: %v", err)
+	}
+
+	stale := dir + "test.log.2020-01-01T00:00:00Z.gz"
+	if err := os.WriteFile(stale, []byte("stale"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	staleTime := clock.Add(-48 * time.Hour)
+	if err := os.Chtimes(stale, staleTime, staleTime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	fresh := dir + "test.log.2020-01-09T00:00:00Z.gz"
+	if err := os.WriteFile(fresh, []byte("fresh"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	freshTime := clock.Add(-1 * time.Hour)
+	if err := os.Chtimes(fresh, freshTime, freshTime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	if err := h.pruneLogs(); err != nil {
+		t.Fatalf("pruneLogs: %v", err)
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatal("expected .gz file older than maxage to be pruned")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Fatalf("expected fresh .gz file to remain: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+// Package format defines the pluggable output format used by the file
+// adapter to turn a router.Message into the bytes written to a log file.
+package format
+
+import (
+	"fmt"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Formatter renders a single log message.
+type Formatter interface {
+	Format(message *router.Message) ([]byte, error)
+}
+
+// Factory builds a Formatter for a route, reading any options (e.g.
+// structured_data) it needs from route.Options.
+type Factory func(route *router.Route) (Formatter, error)
+
+var factories = map[string]Factory{}
+
+// Register makes a Formatter available under name. It is meant to be called
+// from a format subpackage's init() so that adding a new format doesn't
+// require changes to the file adapter.
+func Register(name string, factory Factory) {
+	factories[name] = factory
+}
+
+// New builds the Formatter registered under name for route. It returns an
+// error if name hasn't been registered.
+func New(name string, route *router.Route) (Formatter, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("format: unknown format %q", name)
+	}
+	return factory(route)
+}
@@ -0,0 +1,55 @@
+// Package gelf implements the "gelf" output format: Graylog Extended Log
+// Format, a JSON document with a handful of required/standardized fields
+// plus arbitrary "_"-prefixed additional fields.
+package gelf
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/erbrecht/logspout-file-module/format"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	format.Register("gelf", New)
+}
+
+const gelfVersion = "1.1"
+
+// Formatter renders messages as GELF JSON documents.
+type Formatter struct {
+	host string
+}
+
+// New builds the gelf Formatter. It takes no route options.
+func New(route *router.Route) (format.Formatter, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "-"
+	}
+	return &Formatter{host: host}, nil
+}
+
+// Format implements format.Formatter.
+func (f *Formatter) Format(message *router.Message) ([]byte, error) {
+	doc := map[string]interface{}{
+		"version":       gelfVersion,
+		"host":          f.host,
+		"short_message": message.Data,
+		"timestamp":     float64(message.Time.UnixNano()) / 1e9,
+		"level":         6, // informational; logspout doesn't expose a severity
+		"_container":    message.Container.Name,
+		"_image":        message.Container.Config.Image,
+		"_source":       message.Source,
+	}
+	for k, v := range message.Container.Config.Labels {
+		doc["_labels."+k] = v
+	}
+
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, '\n'), nil
+}
@@ -0,0 +1,50 @@
+package gelf
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// TestFormatProducesWellFormedJSONWithSpecialCharacters confirms label
+// values containing quotes, newlines and unicode survive a round trip
+// through encoding/json without corrupting the document.
+func TestFormatProducesWellFormedJSONWithSpecialCharacters(t *testing.T) {
+	f := &Formatter{host: "test-host"}
+
+	message := &router.Message{
+		Container: &docker.Container{
+			Name: "web-1",
+			Config: &docker.Config{
+				Image:  "web:latest",
+				Labels: map[string]string{"note": "has \"quotes\", a\nnewline, and ünïcode"},
+			},
+		},
+		Time:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Source: "stdout",
+		Data:   "hello",
+	}
+
+	buf, err := f.Format(message)
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		t.Fatalf("output isn't valid JSON: %v\n%s", err, buf)
+	}
+
+	if doc["_labels.note"] != "has \"quotes\", a\nnewline, and ünïcode" {
+		t.Fatalf("_labels.note = %v, want the label value round-tripped as-is", doc["_labels.note"])
+	}
+	if doc["version"] != gelfVersion {
+		t.Fatalf("version = %v, want %v", doc["version"], gelfVersion)
+	}
+	if doc["_container"] != "web-1" {
+		t.Fatalf("_container = %v, want web-1", doc["_container"])
+	}
+}
@@ -0,0 +1,68 @@
+// Package json implements the default "json" output format: one JSON
+// object per line containing the container name, its labels, a timestamp,
+// the source stream, and the log line itself.
+package json
+
+import (
+	"encoding/json"
+
+	"github.com/erbrecht/logspout-file-module/format"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	format.Register("json", New)
+}
+
+// Formatter renders messages as a line of JSON.
+type Formatter struct {
+	structuredData bool
+}
+
+// New builds the json Formatter. Set the structured_data=true route option
+// when the container already emits JSON so the data line is embedded as-is
+// instead of being escaped into a JSON string, matching the adapter's
+// original structured_data behavior.
+func New(route *router.Route) (format.Formatter, error) {
+	return &Formatter{structuredData: route.Options["structured_data"] == "true"}, nil
+}
+
+type record struct {
+	Container string            `json:"container"`
+	Labels    map[string]string `json:"labels"`
+	Timestamp string            `json:"timestamp"`
+	Source    string            `json:"source"`
+	Line      json.RawMessage   `json:"line"`
+}
+
+// Format implements format.Formatter.
+func (f *Formatter) Format(message *router.Message) ([]byte, error) {
+	var line json.RawMessage
+	if f.structuredData && json.Valid([]byte(message.Data)) {
+		line = json.RawMessage(message.Data)
+	} else {
+		// Either structured_data is off, or the container emitted a line
+		// that isn't valid JSON (a banner, a stack trace, pre-init output).
+		// Embed it as an escaped string rather than failing the whole
+		// record and losing the line.
+		data, err := json.Marshal(message.Data)
+		if err != nil {
+			return nil, err
+		}
+		line = data
+	}
+
+	r := record{
+		Container: message.Container.Name,
+		Labels:    message.Container.Config.Labels,
+		Timestamp: message.Time.Format("2006-01-02T15:04:05Z0700"),
+		Source:    message.Source,
+		Line:      line,
+	}
+
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, '\n'), nil
+}
@@ -0,0 +1,66 @@
+package json
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func testMessage(data string) *router.Message {
+	return &router.Message{
+		Container: &docker.Container{
+			Name:   "web-1",
+			Config: &docker.Config{Labels: map[string]string{"app": "web"}},
+		},
+		Time:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Source: "stdout",
+		Data:   data,
+	}
+}
+
+// TestFormatStructuredDataFallsBackOnInvalidJSON guards against the bug
+// where, with structured_data=true, a non-JSON line (a banner, a stack
+// trace, pre-init output) made Format return an error instead of embedding
+// the line as a string, silently dropping the message in Stream().
+func TestFormatStructuredDataFallsBackOnInvalidJSON(t *testing.T) {
+	f := &Formatter{structuredData: true}
+
+	buf, err := f.Format(testMessage("Starting up, please wait..."))
+	if err != nil {
+		t.Fatalf("Format returned error for non-JSON line: %v", err)
+	}
+
+	var r struct {
+		Line string `json:"line"`
+	}
+	if err := json.Unmarshal(buf, &r); err != nil {
+		t.Fatalf("output isn't valid JSON: %v\n%s", err, buf)
+	}
+	if r.Line != "Starting up, please wait..." {
+		t.Fatalf("Line = %q, want the original text", r.Line)
+	}
+}
+
+// TestFormatStructuredDataEmbedsValidJSON confirms the structured_data=true
+// fast path still embeds already-valid JSON as-is rather than escaping it.
+func TestFormatStructuredDataEmbedsValidJSON(t *testing.T) {
+	f := &Formatter{structuredData: true}
+
+	buf, err := f.Format(testMessage(`{"level":"info","msg":"ready"}`))
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+
+	var r struct {
+		Line json.RawMessage `json:"line"`
+	}
+	if err := json.Unmarshal(buf, &r); err != nil {
+		t.Fatalf("output isn't valid JSON: %v\n%s", err, buf)
+	}
+	if string(r.Line) != `{"level":"info","msg":"ready"}` {
+		t.Fatalf("Line = %s, want the structured data embedded as-is", r.Line)
+	}
+}
@@ -0,0 +1,71 @@
+// Package logfmt implements the "logfmt" output format: space-separated
+// key=value pairs, one line per message, in the style popularized by
+// Heroku and used throughout the Go ecosystem.
+package logfmt
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/erbrecht/logspout-file-module/format"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	format.Register("logfmt", New)
+}
+
+// Formatter renders messages as logfmt key=value pairs.
+type Formatter struct{}
+
+// New builds the logfmt Formatter. It takes no route options.
+func New(route *router.Route) (format.Formatter, error) {
+	return &Formatter{}, nil
+}
+
+// Format implements format.Formatter.
+func (f *Formatter) Format(message *router.Message) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	writePair(buf, "container", message.Container.Name)
+	writePair(buf, "source", message.Source)
+	writePair(buf, "timestamp", message.Time.Format("2006-01-02T15:04:05Z0700"))
+
+	labelKeys := make([]string, 0, len(message.Container.Config.Labels))
+	for k := range message.Container.Config.Labels {
+		labelKeys = append(labelKeys, k)
+	}
+	sort.Strings(labelKeys)
+	for _, k := range labelKeys {
+		writePair(buf, "label."+k, message.Container.Config.Labels[k])
+	}
+
+	writePair(buf, "msg", message.Data)
+	buf.WriteByte('\n')
+
+	return buf.Bytes(), nil
+}
+
+// writePair appends a key=value pair to buf, quoting the value if it needs
+// escaping (is empty, or contains a space, tab, newline, `=` or `"`).
+func writePair(buf *bytes.Buffer, key, value string) {
+	if buf.Len() > 0 {
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	if needsQuoting(value) {
+		buf.WriteString(strconv.Quote(value))
+	} else {
+		buf.WriteString(value)
+	}
+}
+
+func needsQuoting(value string) bool {
+	if value == "" {
+		return true
+	}
+	return strings.ContainsAny(value, " \t\"=\n")
+}
@@ -0,0 +1,69 @@
+package logfmt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func testMessage(labels map[string]string, data string) *router.Message {
+	return &router.Message{
+		Container: &docker.Container{
+			Name:   "web-1",
+			Config: &docker.Config{Labels: labels},
+		},
+		Time:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Source: "stdout",
+		Data:   data,
+	}
+}
+
+// TestFormatQuotesSpecialCharacters confirms that label values and the
+// message itself are quoted whenever they contain characters that would
+// otherwise break logfmt's space-separated key=value parsing.
+func TestFormatQuotesSpecialCharacters(t *testing.T) {
+	buf, err := (&Formatter{}).Format(testMessage(
+		map[string]string{"env": "prod team", "note": `has "quotes"`},
+		"GET /healthz 200",
+	))
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	line := string(buf)
+
+	if !strings.Contains(line, `label.env="prod team"`) {
+		t.Fatalf("expected quoted label with space, got %q", line)
+	}
+	if !strings.Contains(line, `label.note="has \"quotes\""`) {
+		t.Fatalf("expected quoted+escaped label with embedded quotes, got %q", line)
+	}
+	if !strings.Contains(line, `msg="GET /healthz 200"`) {
+		t.Fatalf("expected msg quoted since it contains spaces, got %q", line)
+	}
+	if !strings.HasSuffix(line, "\n") {
+		t.Fatalf("expected line to end in a newline, got %q", line)
+	}
+}
+
+// TestFormatLeavesSimpleValuesUnquoted confirms values with no special
+// characters are left bare, matching logfmt convention.
+func TestFormatLeavesSimpleValuesUnquoted(t *testing.T) {
+	buf, err := (&Formatter{}).Format(testMessage(
+		map[string]string{"app": "web"},
+		"ready",
+	))
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	line := string(buf)
+
+	if !strings.Contains(line, "label.app=web") {
+		t.Fatalf("expected unquoted label.app=web, got %q", line)
+	}
+	if !strings.Contains(line, "msg=ready") {
+		t.Fatalf("expected unquoted msg=ready, got %q", line)
+	}
+}
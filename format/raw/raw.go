@@ -0,0 +1,30 @@
+// Package raw implements the "raw" output format: just the log line, with
+// no container metadata attached.
+package raw
+
+import (
+	"strings"
+
+	"github.com/erbrecht/logspout-file-module/format"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	format.Register("raw", New)
+}
+
+// Formatter writes message.Data unmodified, one line per message.
+type Formatter struct{}
+
+// New builds the raw Formatter. It takes no route options.
+func New(route *router.Route) (format.Formatter, error) {
+	return &Formatter{}, nil
+}
+
+// Format implements format.Formatter.
+func (f *Formatter) Format(message *router.Message) ([]byte, error) {
+	if strings.HasSuffix(message.Data, "\n") {
+		return []byte(message.Data), nil
+	}
+	return []byte(message.Data + "\n"), nil
+}
@@ -0,0 +1,31 @@
+package raw
+
+import (
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestFormatAppendsNewlineWhenMissing(t *testing.T) {
+	f := &Formatter{}
+
+	buf, err := f.Format(&router.Message{Data: "hello"})
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	if string(buf) != "hello\n" {
+		t.Fatalf("Format = %q, want %q", buf, "hello\n")
+	}
+}
+
+func TestFormatDoesNotDoubleNewline(t *testing.T) {
+	f := &Formatter{}
+
+	buf, err := f.Format(&router.Message{Data: "hello\n"})
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	if string(buf) != "hello\n" {
+		t.Fatalf("Format = %q, want %q", buf, "hello\n")
+	}
+}
@@ -0,0 +1,81 @@
+// Package syslog5424 implements the "syslog5424" output format: RFC 5424
+// syslog, with a structured-data block derived from the container's labels.
+package syslog5424
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/erbrecht/logspout-file-module/format"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	format.Register("syslog5424", New)
+}
+
+const (
+	defaultFacility = 1 // user-level messages
+	defaultSeverity = 6 // informational
+)
+
+// Formatter renders messages as RFC 5424 syslog lines.
+type Formatter struct{}
+
+// New builds the syslog5424 Formatter. It takes no route options.
+func New(route *router.Route) (format.Formatter, error) {
+	return &Formatter{}, nil
+}
+
+// Format implements format.Formatter.
+func (f *Formatter) Format(message *router.Message) ([]byte, error) {
+	pri := defaultFacility*8 + defaultSeverity
+	timestamp := message.Time.Format("2006-01-02T15:04:05.000000Z07:00")
+
+	hostname := "-"
+	appName := nilOrDash(message.Container.Name)
+	procID := "-"
+	msgID := nilOrDash(message.Source)
+
+	sd := structuredData(message.Container.Config.Labels)
+
+	line := fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s\n",
+		pri, timestamp, hostname, appName, procID, msgID, sd, message.Data)
+	return []byte(line), nil
+}
+
+func nilOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// structuredData renders container labels as a single RFC 5424
+// STRUCTURED-DATA element, e.g. [labels app="web" env="prod"].
+func structuredData(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("[labels")
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%q", sdSafeName(k), labels[k])
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// sdSafeName replaces characters not allowed in an RFC 5424 SD-NAME
+// (PARAM-NAME), namely '=', ' ', ']', '"', with '_'.
+func sdSafeName(name string) string {
+	return strings.NewReplacer("=", "_", " ", "_", "]", "_", "\"", "_").Replace(name)
+}
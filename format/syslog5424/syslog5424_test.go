@@ -0,0 +1,71 @@
+package syslog5424
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func testMessage(labels map[string]string) *router.Message {
+	return &router.Message{
+		Container: &docker.Container{
+			Name:   "web-1",
+			Config: &docker.Config{Labels: labels},
+		},
+		Time:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Source: "stdout",
+		Data:   "hello",
+	}
+}
+
+// TestFormatSanitizesLabelKeysWithSpecialCharacters confirms label names
+// containing characters not allowed in an RFC 5424 SD-NAME ('=', ' ', ']',
+// '"') are sanitized rather than corrupting the STRUCTURED-DATA element.
+func TestFormatSanitizesLabelKeysWithSpecialCharacters(t *testing.T) {
+	buf, err := (&Formatter{}).Format(testMessage(map[string]string{
+		`we ird=name"]`: "value",
+	}))
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	line := string(buf)
+
+	if strings.Contains(line, `we ird=name"]=`) {
+		t.Fatalf("expected the raw label key to be sanitized, got %q", line)
+	}
+	if !strings.Contains(line, `we_ird_name__="value"`) {
+		t.Fatalf("expected sanitized key we_ird_name__, got %q", line)
+	}
+}
+
+// TestFormatQuotesLabelValues confirms label values are rendered as a
+// quoted PARAM-VALUE, with embedded quotes escaped.
+func TestFormatQuotesLabelValues(t *testing.T) {
+	buf, err := (&Formatter{}).Format(testMessage(map[string]string{
+		"note": `has "quotes"`,
+	}))
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	line := string(buf)
+
+	if !strings.Contains(line, `note="has \"quotes\""`) {
+		t.Fatalf("expected escaped quoted value, got %q", line)
+	}
+}
+
+// TestFormatUsesDashForNoLabels confirms the STRUCTURED-DATA element is "-"
+// (NILVALUE) when the container has no labels.
+func TestFormatUsesDashForNoLabels(t *testing.T) {
+	buf, err := (&Formatter{}).Format(testMessage(nil))
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	fields := strings.SplitN(string(buf), " ", 8)
+	if len(fields) < 7 || fields[6] != "-" {
+		t.Fatalf("expected STRUCTURED-DATA field to be \"-\", got %q", string(buf))
+	}
+}
@@ -0,0 +1,136 @@
+package file
+
+import (
+	"container/list"
+	"os"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestParseRetentionDuration(t *testing.T) {
+	cases := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"168h", 168 * time.Hour},
+		{"30m", 30 * time.Minute},
+		{"7d", 7 * 24 * time.Hour},
+	}
+	for _, c := range cases {
+		got, err := parseRetentionDuration(c.in)
+		if err != nil {
+			t.Errorf("parseRetentionDuration(%q): %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseRetentionDuration(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+
+	if _, err := parseRetentionDuration("nope"); err == nil {
+		t.Error("expected error for invalid duration")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int64
+	}{
+		{"1048576", 1048576},
+		{"10GB", 10 * 1024 * 1024 * 1024},
+		{"100MB", 100 * 1024 * 1024},
+		{"512KB", 512 * 1024},
+		{"10B", 10},
+	}
+	for _, c := range cases {
+		got, err := parseSize(c.in)
+		if err != nil {
+			t.Errorf("parseSize(%q): %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseSize(%q) = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
+
+// TestPruneLogsDoesNotPanicBelowMaxFileCount guards against the historical
+// bug where files[0 : len(files)-maxfilecount] panicked whenever fewer than
+// maxfilecount rotated files existed.
+func TestPruneLogsDoesNotPanicBelowMaxFileCount(t *testing.T) {
+	dir := t.TempDir() + "/"
+	tmpl, err := template.New("filename").Parse("test.log")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	a := &Adapter{
+		logdir:       dir,
+		filenameTmpl: tmpl,
+		maxfilesize:  1024 * 1024,
+		maxfilecount: 10,
+		maxOpenFiles: defaultMaxOpenFiles,
+		now:          time.Now,
+		files:        map[string]*fileHandle{},
+		lru:          list.New(),
+		lruElems:     map[string]*list.Element{},
+	}
+
+	h, err := a.getOrCreateHandle("test.log")
+	if err != nil {
+		t.Fatalf("getOrCreateHandle: %v", err)
+	}
+
+	// only one rotated file exists, well below maxfilecount=10
+	if err := h.rotate(); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+}
+
+// TestPruneLogsComposesLimits verifies that maxage removes files on its own
+// even when maxfilecount alone would keep them.
+func TestPruneLogsComposesLimits(t *testing.T) {
+	dir := t.TempDir() + "/"
+	tmpl, err := template.New("filename").Parse("test.log")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	clock := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	a := &Adapter{
+		logdir:       dir,
+		filenameTmpl: tmpl,
+		maxfilesize:  1024 * 1024,
+		maxfilecount: 100, // high enough that count alone wouldn't prune
+		maxage:       24 * time.Hour,
+		now:          func() time.Time { return clock },
+		files:        map[string]*fileHandle{},
+		lru:          list.New(),
+		lruElems:     map[string]*list.Element{},
+	}
+
+	h, err := a.getOrCreateHandle("test.log")
+	if err != nil {
+		t.Fatalf("getOrCreateHandle: %v", err)
+	}
+
+	// a rotated file old enough to violate maxage
+	old := dir + "test.log.2020-01-01T00:00:00Z"
+	if err := os.WriteFile(old, []byte("stale"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	staleTime := clock.Add(-48 * time.Hour)
+	if err := os.Chtimes(old, staleTime, staleTime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	if err := h.pruneLogs(); err != nil {
+		t.Fatalf("pruneLogs: %v", err)
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatal("expected file older than maxage to be pruned")
+	}
+}